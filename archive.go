@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// archiveEntry describes one file to stream into an exported archive:
+// sourceName is the actual cache entry (possibly brotli/gzip compressed),
+// while archiveName is the name the uncompressed asset is written under.
+type archiveEntry struct {
+	sourceName  string
+	archiveName string
+	encoding    string // "", "br", or "gzip"
+}
+
+func newArchiveEntry(sourceName string) archiveEntry {
+	switch {
+	case strings.HasSuffix(sourceName, ".br"):
+		return archiveEntry{sourceName: sourceName, archiveName: strings.TrimSuffix(sourceName, ".br"), encoding: "br"}
+	case strings.HasSuffix(sourceName, ".gz"):
+		return archiveEntry{sourceName: sourceName, archiveName: strings.TrimSuffix(sourceName, ".gz"), encoding: "gzip"}
+	default:
+		return archiveEntry{sourceName: sourceName, archiveName: sourceName}
+	}
+}
+
+// collectArchiveEntries resolves the set of cache entries to bundle. When
+// requested is empty the whole cache is exported, deduped by archiveName
+// and preferring brotli over gzip over the plain copy so the archive never
+// contains the same asset twice. An explicit request is trusted as-is.
+func collectArchiveEntries(requested []string) ([]archiveEntry, error) {
+	if len(requested) > 0 {
+		entries := make([]archiveEntry, 0, len(requested))
+		for _, name := range requested {
+			if strings.Contains(name, "..") {
+				return nil, fmt.Errorf("invalid path %q", name)
+			}
+			entries = append(entries, newArchiveEntry(name))
+		}
+		return entries, nil
+	}
+
+	names, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		rankBr = iota
+		rankGzip
+		rankPlain
+	)
+	best := make(map[string]archiveEntry)
+	rank := make(map[string]int)
+	for _, name := range names {
+		e := newArchiveEntry(name)
+		r := rankPlain
+		switch e.encoding {
+		case "br":
+			r = rankBr
+		case "gzip":
+			r = rankGzip
+		}
+		if existingRank, ok := rank[e.archiveName]; !ok || r < existingRank {
+			best[e.archiveName] = e
+			rank[e.archiveName] = r
+		}
+	}
+	entries := make([]archiveEntry, 0, len(best))
+	for _, e := range best {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].archiveName < entries[j].archiveName })
+	return entries, nil
+}
+
+// requestedArchivePaths reads the optional list of paths to bundle, either
+// from repeated POST form values named "path" or, for a plain GET, none
+// (meaning "export everything currently cached").
+func requestedArchivePaths(r *http.Request) ([]string, error) {
+	if r.Method != http.MethodPost {
+		return nil, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return r.Form["path"], nil
+}
+
+// openArchiveEntry opens e's underlying cache entry and, for a compressed
+// source, wraps it in a decompressing reader so the archive always holds
+// the original uncompressed bytes under the original asset name.
+func openArchiveEntry(e archiveEntry) (io.ReadCloser, error) {
+	f, err := cache.Open(e.sourceName)
+	if err != nil {
+		return nil, err
+	}
+	switch e.encoding {
+	case "br":
+		return struct {
+			io.Reader
+			io.Closer
+		}{brotli.NewReader(f), f}, nil
+	case "gzip":
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gr, f}, nil
+	default:
+		return f, nil
+	}
+}
+
+// handleArchiveTarGz streams GET /vcsky.tar.gz (the whole cache) or POST
+// /vcsky.tar.gz (a chosen subset, via repeated "path" form values) as a
+// single gzip-compressed tar, built on the fly with no buffering to memory.
+func handleArchiveTarGz(w http.ResponseWriter, r *http.Request) {
+	paths, err := requestedArchivePaths(r)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	entries, err := collectArchiveEntries(paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="vcsky.tar.gz"`)
+	w.WriteHeader(http.StatusOK)
+
+	gw := gzip.NewWriter(w)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for _, e := range entries {
+		if err := writeTarEntry(tw, e); err != nil {
+			log.Printf("Error archiving %s: %v", e.sourceName, err)
+			return
+		}
+	}
+}
+
+// writeTarEntry adds e to tw. tar headers require the entry size up front,
+// so a compressed source is first decompressed to a spooled temp file on
+// disk (never buffered fully in memory) to learn its final size.
+func writeTarEntry(tw *tar.Writer, e archiveEntry) error {
+	rc, err := openArchiveEntry(e)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if e.encoding == "" {
+		info, err := cache.Stat(e.sourceName)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: e.archiveName, Mode: 0644, Size: info.Size()}); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, rc)
+		return err
+	}
+
+	spool, err := os.CreateTemp("", "revcdos-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, rc)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: e.archiveName, Mode: 0644, Size: size}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, spool)
+	return err
+}
+
+// handleArchiveZip streams GET /vcsky.zip (the whole cache) or POST
+// /vcsky.zip (a chosen subset) as a single zip archive. Unlike tar, zip
+// entries don't need their size known up front, so compressed sources are
+// decompressed straight into the archive with no spooling required.
+func handleArchiveZip(w http.ResponseWriter, r *http.Request) {
+	paths, err := requestedArchivePaths(r)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	entries, err := collectArchiveEntries(paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="vcsky.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, e := range entries {
+		if err := writeZipEntry(zw, e); err != nil {
+			log.Printf("Error archiving %s: %v", e.sourceName, err)
+			return
+		}
+	}
+}
+
+func writeZipEntry(zw *zip.Writer, e archiveEntry) error {
+	rc, err := openArchiveEntry(e)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	zf, err := zw.Create(e.archiveName)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zf, rc)
+	return err
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheBackend abstracts cached-asset storage (local disk, in-memory, or
+// S3-compatible). Names are always the slash-separated /vcsky/ path, never
+// an absolute filesystem path.
+type cacheBackend interface {
+	// Open returns name for reading; random-access callers should
+	// type-assert to io.Seeker and fall back to a plain copy otherwise.
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Writer buffers a new entry under name, visible via Open/Stat only
+	// once Commit is called; Discard must be used on any error path.
+	Writer(name string) (cacheWriter, error)
+	// PartWriter opens (creating if necessary) name for appending, for
+	// resumable downloads -- unlike Writer, appended bytes are visible
+	// immediately.
+	PartWriter(name string) (io.WriteCloser, int64, error)
+	Delete(name string) error
+	// List returns every complete entry's name, excluding resume sidecars
+	// and other internal bookkeeping.
+	List() ([]string, error)
+}
+
+type cacheWriter interface {
+	io.Writer
+	Commit() error
+	Discard() error
+}
+
+// newCacheBackend builds the backend selected via -cache-backend/CACHE_BACKEND.
+func newCacheBackend(kind string) (cacheBackend, error) {
+	switch kind {
+	case "", "local":
+		return newLocalBackend(downloadDir)
+	case "memory":
+		return newMemoryBackend(), nil
+	case "s3":
+		return newS3Backend()
+	default:
+		return nil, fmt.Errorf("unknown -cache-backend %q (want local, memory, or s3)", kind)
+	}
+}
+
+// Local filesystem backend: writes land in a sibling temp file first and
+// are renamed into place, so a crashed write never leaves a partial file.
+
+type localBackend struct {
+	dir string
+}
+
+func newLocalBackend(dir string) (*localBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create cache directory %s: %w", dir, err)
+	}
+	return &localBackend{dir: dir}, nil
+}
+
+func (b *localBackend) path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(name))
+}
+
+func (b *localBackend) Open(name string) (fs.File, error) {
+	return os.Open(b.path(name))
+}
+
+func (b *localBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(b.path(name))
+}
+
+func (b *localBackend) Delete(name string) error {
+	return os.Remove(b.path(name))
+}
+
+func (b *localBackend) Writer(name string) (cacheWriter, error) {
+	finalPath := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(finalPath), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &localCacheWriter{tmp: tmp, tmpPath: tmp.Name(), finalPath: finalPath}, nil
+}
+
+type localCacheWriter struct {
+	tmp       *os.File
+	tmpPath   string
+	finalPath string
+}
+
+func (w *localCacheWriter) Write(p []byte) (int, error) {
+	return w.tmp.Write(p)
+}
+
+func (w *localCacheWriter) Commit() error {
+	if err := w.tmp.Close(); err != nil {
+		os.Remove(w.tmpPath)
+		return err
+	}
+	return os.Rename(w.tmpPath, w.finalPath)
+}
+
+func (w *localCacheWriter) Discard() error {
+	w.tmp.Close()
+	return os.Remove(w.tmpPath)
+}
+
+func (b *localBackend) PartWriter(name string) (io.WriteCloser, int64, error) {
+	p := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (b *localBackend) List() ([]string, error) {
+	var names []string
+	err := filepath.Walk(b.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if isInternalCacheName(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// isInternalCacheName reports whether base names a resume sidecar or an
+// in-progress atomic-write temp file rather than a real cache entry.
+func isInternalCacheName(base string) bool {
+	return strings.HasSuffix(base, ".part") || strings.HasSuffix(base, ".part.meta") || strings.HasPrefix(base, ".tmp-")
+}
+
+// In-memory backend, for ephemeral containers that'd rather eat the CDN
+// round-trip on restart than manage a volume.
+
+type memoryBackend struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{entries: make(map[string]*memoryEntry)}
+}
+
+func (b *memoryBackend) Open(name string) (fs.File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e, ok := b.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memoryFile{
+		name:    name,
+		modTime: e.modTime,
+		Reader:  bytes.NewReader(e.data),
+		size:    int64(len(e.data)),
+	}, nil
+}
+
+func (b *memoryBackend) Stat(name string) (fs.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e, ok := b.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memoryFileInfo{name: filepath.Base(name), size: int64(len(e.data)), modTime: e.modTime}, nil
+}
+
+func (b *memoryBackend) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(b.entries, name)
+	return nil
+}
+
+func (b *memoryBackend) Writer(name string) (cacheWriter, error) {
+	return &memoryCacheWriter{backend: b, name: name}, nil
+}
+
+type memoryCacheWriter struct {
+	backend *memoryBackend
+	name    string
+	buf     bytes.Buffer
+}
+
+func (w *memoryCacheWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryCacheWriter) Commit() error {
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+	w.backend.entries[w.name] = &memoryEntry{data: w.buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (w *memoryCacheWriter) Discard() error {
+	w.buf.Reset()
+	return nil
+}
+
+func (b *memoryBackend) PartWriter(name string) (io.WriteCloser, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[name]
+	if !ok {
+		e = &memoryEntry{modTime: time.Now()}
+		b.entries[name] = e
+	}
+	return &memoryPartWriter{backend: b, name: name}, int64(len(e.data)), nil
+}
+
+// memoryPartWriter appends directly to the backend's entry under name,
+// locking per Write so readers only ever see a consistent slice.
+type memoryPartWriter struct {
+	backend *memoryBackend
+	name    string
+}
+
+func (w *memoryPartWriter) Write(p []byte) (int, error) {
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+	e := w.backend.entries[w.name]
+	e.data = append(e.data, p...)
+	e.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *memoryPartWriter) Close() error { return nil }
+
+func (b *memoryBackend) List() ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		if isInternalCacheName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// memoryFile implements fs.File (and io.ReadSeeker, since bytes.Reader
+// already supports it) over a buffered entry.
+type memoryFile struct {
+	*bytes.Reader
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) {
+	return memoryFileInfo{name: filepath.Base(f.name), size: f.size, modTime: f.modTime}, nil
+}
+
+func (f *memoryFile) Close() error { return nil }
+
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memoryFileInfo) Name() string       { return fi.name }
+func (fi memoryFileInfo) Size() int64        { return fi.size }
+func (fi memoryFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi memoryFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memoryFileInfo) IsDir() bool        { return false }
+func (fi memoryFileInfo) Sys() any           { return nil }
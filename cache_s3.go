@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var (
+	s3Bucket   string
+	s3Prefix   string
+	s3Endpoint string
+)
+
+func init() {
+	flag.StringVar(&s3Bucket, "s3-bucket", "", "S3 bucket for -cache-backend=s3")
+	flag.StringVar(&s3Prefix, "s3-prefix", "", "Key prefix for -cache-backend=s3")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", "", "Custom S3-compatible endpoint (e.g. for MinIO)")
+}
+
+// s3Backend stores cache entries as objects in an S3-compatible bucket.
+// Credentials and region come from the standard AWS env vars / shared
+// config; -s3-endpoint overrides the endpoint for MinIO and similar stores.
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Backend() (*s3Backend, error) {
+	if s3Bucket == "" {
+		return nil, errors.New("-cache-backend=s3 requires -s3-bucket (or S3_BUCKET)")
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if s3Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &s3Backend{client: client, bucket: s3Bucket, prefix: strings.Trim(s3Prefix, "/")}, nil
+}
+
+func (b *s3Backend) key(name string) string {
+	if b.prefix == "" {
+		return name
+	}
+	return path.Join(b.prefix, name)
+}
+
+// Open returns an io.ReadSeeker that streams from a ranged GetObject rather
+// than buffering the whole object, since serveLocalFile hands this straight
+// to http.ServeContent for Range support.
+func (b *s3Backend) Open(name string) (fs.File, error) {
+	info, err := b.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3RangeFile{backend: b, name: name, key: b.key(name), size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// s3RangeFile is an fs.File/io.ReadSeeker over a single S3 object. It opens
+// a GetObject body lazily at the current offset; Seek just drops the body
+// so the next Read reopens at the new offset.
+type s3RangeFile struct {
+	backend *s3Backend
+	name    string // logical cache name, for error messages (key is prefixed)
+	key     string
+	size    int64
+	modTime time.Time
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (f *s3RangeFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		out, err := f.backend.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(f.backend.bucket),
+			Key:    aws.String(f.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", f.offset)),
+		})
+		if err != nil {
+			return 0, translateS3NotFound(f.name, err)
+		}
+		f.body = out.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3RangeFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, fmt.Errorf("s3RangeFile: invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.New("s3RangeFile: negative seek position")
+	}
+	if abs != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = abs
+	return f.offset, nil
+}
+
+func (f *s3RangeFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *s3RangeFile) Stat() (fs.FileInfo, error) {
+	return memoryFileInfo{name: path.Base(f.key), size: f.size, modTime: f.modTime}, nil
+}
+
+func (b *s3Backend) Stat(name string) (fs.FileInfo, error) {
+	out, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	if err != nil {
+		return nil, translateS3NotFound(name, err)
+	}
+	size := int64(0)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	modTime := time.Now()
+	if out.LastModified != nil {
+		modTime = *out.LastModified
+	}
+	return memoryFileInfo{name: path.Base(name), size: size, modTime: modTime}, nil
+}
+
+func (b *s3Backend) Delete(name string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	return err
+}
+
+func (b *s3Backend) Writer(name string) (cacheWriter, error) {
+	return &s3CacheWriter{backend: b, name: name}, nil
+}
+
+type s3CacheWriter struct {
+	backend *s3Backend
+	name    string
+	buf     bytes.Buffer
+}
+
+func (w *s3CacheWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *s3CacheWriter) Commit() error {
+	_, err := w.backend.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(w.backend.bucket),
+		Key:    aws.String(w.backend.key(w.name)),
+		Body:   bytes.NewReader(w.buf.Bytes()),
+	})
+	return err
+}
+
+func (w *s3CacheWriter) Discard() error {
+	w.buf.Reset()
+	return nil
+}
+
+// PartWriter has no native append on S3: it reads back whatever was
+// previously committed under name, buffers further writes after it, and
+// Close re-uploads the whole object. Safe since the download lock ensures
+// only one holder rewrites name at a time.
+func (b *s3Backend) PartWriter(name string) (io.WriteCloser, int64, error) {
+	existing, err := b.readAll(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, err
+		}
+		existing = nil
+	}
+	w := &s3PartWriter{backend: b, name: name}
+	w.buf.Write(existing)
+	return w, int64(len(existing)), nil
+}
+
+func (b *s3Backend) readAll(name string) ([]byte, error) {
+	out, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	if err != nil {
+		return nil, translateS3NotFound(name, err)
+	}
+	data, err := io.ReadAll(out.Body)
+	out.Body.Close()
+	return data, err
+}
+
+type s3PartWriter struct {
+	backend *s3Backend
+	name    string
+	buf     bytes.Buffer
+}
+
+func (w *s3PartWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *s3PartWriter) Close() error {
+	_, err := w.backend.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(w.backend.bucket),
+		Key:    aws.String(w.backend.key(w.name)),
+		Body:   bytes.NewReader(w.buf.Bytes()),
+	})
+	return err
+}
+
+func (b *s3Backend) List() ([]string, error) {
+	var names []string
+	var continuationToken *string
+	for {
+		out, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(b.bucket),
+			Prefix:            aws.String(b.prefix),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			key := aws.ToString(obj.Key)
+			name := key
+			if b.prefix != "" {
+				name = strings.TrimPrefix(strings.TrimPrefix(key, b.prefix), "/")
+			}
+			if name == "" || isInternalCacheName(name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		continuationToken = out.NextContinuationToken
+	}
+	return names, nil
+}
+
+// translateS3NotFound maps the not-found errors the S3 SDK models --
+// *types.NoSuchKey from GetObject, *types.NotFound from HeadObject -- to
+// fs.ErrNotExist, so callers like serveLocalFile's os.IsNotExist check
+// behave the same way they do for the local backend.
+func translateS3NotFound(name string, err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// TestTranslateS3NotFound guards against HeadObject's not-found error shape
+// (*types.NotFound) going unmapped: Stat must translate it to fs.ErrNotExist
+// the same way it already does for GetObject's *types.NoSuchKey, or callers
+// like serveLocalFile's os.IsNotExist check never take the cache-miss path.
+func TestTranslateS3NotFound(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		wantNotEx bool
+	}{
+		{"NoSuchKey from GetObject", &types.NoSuchKey{}, true},
+		{"NotFound from HeadObject", &types.NotFound{}, true},
+		{"unrelated error", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := translateS3NotFound("some/name", c.err)
+			if c.wantNotEx {
+				if !errors.Is(got, fs.ErrNotExist) {
+					t.Errorf("translateS3NotFound(%v) = %v, want fs.ErrNotExist", c.err, got)
+				}
+				return
+			}
+			if !errors.Is(got, c.err) {
+				t.Errorf("translateS3NotFound(%v) = %v, want err returned unchanged", c.err, got)
+			}
+		})
+	}
+}
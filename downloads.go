@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// downloadState is the lifecycle of a single cache entry being fetched by
+// doDownloadAssets, doDownloadAudio, or downloadWithWorkers.
+type downloadState string
+
+const (
+	downloadQueued     downloadState = "queued"
+	downloadInProgress downloadState = "in_progress"
+	downloadOK         downloadState = "ok"
+	downloadFailed     downloadState = "failed"
+)
+
+// fileDownload is the tracker's view of one file, snapshotted as JSON for
+// both /api/downloads and each /api/downloads/stream transition.
+type fileDownload struct {
+	Name      string        `json:"name"`
+	State     downloadState `json:"state"`
+	Bytes     int64         `json:"bytes"`
+	Total     int64         `json:"total,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	StartedAt time.Time     `json:"started_at,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Elapsed   float64       `json:"elapsed_seconds"`
+}
+
+func (f fileDownload) elapsedSeconds() float64 {
+	if f.StartedAt.IsZero() {
+		return 0
+	}
+	end := f.UpdatedAt
+	if f.State == downloadQueued || f.State == downloadInProgress {
+		end = time.Now()
+	}
+	return end.Sub(f.StartedAt).Seconds()
+}
+
+// downloadCounters aggregates file states for the snapshot and SSE feed, so
+// a UI doesn't need to count files itself.
+type downloadCounters struct {
+	Queued     int `json:"queued"`
+	InProgress int `json:"in_progress"`
+	OK         int `json:"ok"`
+	Failed     int `json:"failed"`
+	Total      int `json:"total"`
+}
+
+// downloadEvent is one SSE message: either a single file's state transition
+// or a heartbeat, both carrying the latest aggregated counters.
+type downloadEvent struct {
+	Type     string           `json:"type"` // "update" or "heartbeat"
+	File     *fileDownload    `json:"file,omitempty"`
+	Counters downloadCounters `json:"counters"`
+}
+
+// DownloadTracker records per-file progress for the background cache
+// warmer and fans state transitions out to any number of SSE subscribers.
+// downloadTracker is the single process-wide instance.
+type DownloadTracker struct {
+	mu    sync.Mutex
+	files map[string]*fileDownload
+	subs  map[chan downloadEvent]struct{}
+}
+
+func newDownloadTracker() *DownloadTracker {
+	return &DownloadTracker{
+		files: make(map[string]*fileDownload),
+		subs:  make(map[chan downloadEvent]struct{}),
+	}
+}
+
+var downloadTracker = newDownloadTracker()
+
+func (t *DownloadTracker) Queue(name string) {
+	t.update(name, func(f *fileDownload) {
+		f.State = downloadQueued
+	})
+}
+
+func (t *DownloadTracker) Start(name string) {
+	t.update(name, func(f *fileDownload) {
+		f.State = downloadInProgress
+		f.StartedAt = time.Now()
+		f.Error = ""
+	})
+}
+
+// Progress records bytes downloaded so far; total may be -1 when the
+// response didn't carry a Content-Length, in which case it's left unset.
+func (t *DownloadTracker) Progress(name string, bytes, total int64) {
+	t.update(name, func(f *fileDownload) {
+		f.Bytes = bytes
+		if total >= 0 {
+			f.Total = total
+		}
+	})
+}
+
+func (t *DownloadTracker) Complete(name string, err error) {
+	t.update(name, func(f *fileDownload) {
+		if err != nil {
+			f.State = downloadFailed
+			f.Error = err.Error()
+		} else {
+			f.State = downloadOK
+			if f.Total > 0 {
+				f.Bytes = f.Total
+			}
+		}
+	})
+}
+
+func (t *DownloadTracker) update(name string, mutate func(*fileDownload)) {
+	t.mu.Lock()
+	f, ok := t.files[name]
+	if !ok {
+		f = &fileDownload{Name: name}
+		t.files[name] = f
+	}
+	mutate(f)
+	f.UpdatedAt = time.Now()
+	f.Elapsed = f.elapsedSeconds()
+	snapshot := *f
+	counters := t.countersLocked()
+	subs := make([]chan downloadEvent, 0, len(t.subs))
+	for ch := range t.subs {
+		subs = append(subs, ch)
+	}
+	t.mu.Unlock()
+
+	ev := downloadEvent{Type: "update", File: &snapshot, Counters: counters}
+	for _, ch := range subs {
+		select {
+		case ch <- ev:
+		default:
+			// Subscriber is behind; it'll catch up on the next snapshot or
+			// heartbeat rather than blocking the download worker on a slow
+			// client.
+		}
+	}
+}
+
+func (t *DownloadTracker) countersLocked() downloadCounters {
+	var c downloadCounters
+	for _, f := range t.files {
+		c.Total++
+		switch f.State {
+		case downloadQueued:
+			c.Queued++
+		case downloadInProgress:
+			c.InProgress++
+		case downloadOK:
+			c.OK++
+		case downloadFailed:
+			c.Failed++
+		}
+	}
+	return c
+}
+
+// downloadSnapshot is the JSON body of GET /api/downloads.
+type downloadSnapshot struct {
+	Files    []fileDownload   `json:"files"`
+	Counters downloadCounters `json:"counters"`
+}
+
+func (t *DownloadTracker) Snapshot() downloadSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files := make([]fileDownload, 0, len(t.files))
+	for _, f := range t.files {
+		snapshot := *f
+		snapshot.Elapsed = f.elapsedSeconds()
+		files = append(files, snapshot)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return downloadSnapshot{Files: files, Counters: t.countersLocked()}
+}
+
+// Subscribe registers a channel that receives one event per file state
+// transition plus periodic heartbeats (see handleDownloadsStream). Callers
+// must call the returned cancel func once done to avoid leaking the
+// channel and its goroutine-side send attempts.
+func (t *DownloadTracker) Subscribe() (ch chan downloadEvent, cancel func()) {
+	ch = make(chan downloadEvent, 32)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}
+}
+
+func (t *DownloadTracker) heartbeat() downloadEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return downloadEvent{Type: "heartbeat", Counters: t.countersLocked()}
+}
+
+// HTTP handlers
+
+func handleDownloadsSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(downloadTracker.Snapshot()); err != nil {
+		log.Printf("Error encoding downloads snapshot: %v", err)
+	}
+}
+
+// handleDownloadsStream pushes one SSE event per file state transition plus
+// a heartbeat every few seconds, so a UI or health probe can watch the
+// background cache warmer without polling /api/downloads.
+func handleDownloadsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch, cancel := downloadTracker.Subscribe()
+	defer cancel()
+
+	if err := writeSSE(w, downloadEvent{Type: "snapshot", Counters: downloadTracker.Snapshot().Counters}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(5 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if err := writeSSE(w, downloadTracker.heartbeat()); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev downloadEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
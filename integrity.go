@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// manifestEntry is one asset's expected hash and size, as published in
+// dist/manifest.json (or ${cdn}/manifest.json when not embedded).
+type manifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// manifest maps cache entry name to its expected manifestEntry. Populated
+// once at startup by loadManifest; nil (and silently skipped everywhere)
+// when no manifest could be found.
+var manifest map[string]manifestEntry
+
+// loadManifest reads the asset manifest embedded at dist/manifest.json,
+// falling back to fetching it from the CDN. A missing manifest isn't
+// fatal -- integrity checks are just skipped.
+func loadManifest() {
+	data, err := distFS.ReadFile("dist/manifest.json")
+	if err != nil {
+		data, err = fetchManifestFromCDN()
+		if err != nil {
+			log.Printf("Cache integrity: no manifest available (%v), integrity checks disabled", err)
+			return
+		}
+	}
+
+	var m map[string]manifestEntry
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Printf("Cache integrity: cannot parse manifest.json: %v, integrity checks disabled", err)
+		return
+	}
+	manifest = m
+	log.Printf("Cache integrity: loaded manifest with %d entries", len(manifest))
+}
+
+func fetchManifestFromCDN() ([]byte, error) {
+	resp, err := httpClient.Get(strings.TrimSuffix(cdn, "/") + "/manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// integrityError marks a verifyingCopy failure as a hash/size mismatch
+// rather than a plain I/O error, so callers know to discard the .part
+// resume sidecar rather than leave it for a future resume.
+type integrityError struct{ msg string }
+
+func (e *integrityError) Error() string { return e.msg }
+
+// checkManifest compares a completed hash/size pair against name's manifest
+// entry, if it has one. ok is true when there's nothing to check (no
+// manifest entry) or the hash and size both match.
+func checkManifest(name string, hasher hashSum, size int64) (ok bool, mismatch string) {
+	entry, has := manifest[name]
+	if !has {
+		return true, ""
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if sum == entry.SHA256 && size == entry.Size {
+		return true, ""
+	}
+	return false, fmt.Sprintf("got sha256=%s size=%d, want sha256=%s size=%d", sum, size, entry.SHA256, entry.Size)
+}
+
+type hashSum interface {
+	Sum(b []byte) []byte
+}
+
+// verifyingCopy copies r into dst, hashing the stream on the fly against
+// name's manifest entry (if any). Returns an *integrityError if the
+// completed copy doesn't match the expected hash or size.
+func verifyingCopy(dst io.Writer, r io.Reader, name string) (int64, error) {
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hasher), r)
+	if err != nil {
+		return size, err
+	}
+	if ok, mismatch := checkManifest(name, hasher, size); !ok {
+		return size, &integrityError{msg: fmt.Sprintf("integrity check failed for %s: %s", name, mismatch)}
+	}
+	return size, nil
+}
+
+// integrityStatus is one entry's result from verifyCache, used both by
+// -verify and GET /api/integrity.
+type integrityStatus struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Size   int64  `json:"size"`
+	Expect int64  `json:"expected_size"`
+}
+
+// verifyCache re-hashes every cache entry that has a manifest record and
+// reports whether it still matches. Entries with no manifest record are
+// skipped rather than flagged.
+func verifyCache() ([]integrityStatus, error) {
+	if manifest == nil {
+		return nil, fmt.Errorf("no manifest loaded")
+	}
+	names, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []integrityStatus
+	for _, name := range names {
+		entry, ok := manifest[name]
+		if !ok {
+			continue
+		}
+		status := integrityStatus{Name: name, Expect: entry.Size}
+
+		f, err := cache.Open(name)
+		if err != nil {
+			status.Error = err.Error()
+			results = append(results, status)
+			continue
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, f)
+		f.Close()
+		if err != nil {
+			status.Error = err.Error()
+			results = append(results, status)
+			continue
+		}
+		status.Size = size
+		status.OK, status.Error = checkManifest(name, hasher, size)
+		results = append(results, status)
+	}
+	return results, nil
+}
+
+// runVerify implements -verify: re-hash every manifest-covered cache entry
+// and report corrupt ones on stdout.
+func runVerify() error {
+	results, err := verifyCache()
+	if err != nil {
+		return err
+	}
+	corrupt := 0
+	for _, r := range results {
+		if !r.OK {
+			corrupt++
+			log.Printf("CORRUPT %s: %s", r.Name, r.Error)
+		}
+	}
+	log.Printf("Verified %d entries, %d corrupt", len(results), corrupt)
+	if corrupt > 0 {
+		return fmt.Errorf("%d of %d entries failed integrity verification", corrupt, len(results))
+	}
+	return nil
+}
+
+func handleIntegrity(w http.ResponseWriter, r *http.Request) {
+	results, err := verifyCache()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Error encoding integrity report: %v", err)
+	}
+}
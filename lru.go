@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// lruEvent is sent to lruManager.run whenever an entry is written or read,
+// so that recency bookkeeping and eviction happen on a single goroutine
+// instead of racing concurrent requests against os.Remove.
+type lruEvent struct {
+	name string
+	// size is the entry's current size; -1 means "just update recency",
+	// used for a read-hit that doesn't change what's stored.
+	size int64
+}
+
+// lruManager treats the cache as a size-bounded LRU when -cache-max-bytes
+// is set: it tracks (name, size, last-access) in memory rather than
+// relying on filesystem atime, evicting the least-recently-used entries
+// once the tracked total exceeds maxBytes. An in-flight download (see
+// isDownloadActive) is pinned.
+type lruManager struct {
+	maxBytes int64
+	events   chan lruEvent
+
+	size  map[string]int64
+	atime map[string]time.Time
+	total int64
+}
+
+func newLRUManager(maxBytes int64) *lruManager {
+	return &lruManager{
+		maxBytes: maxBytes,
+		events:   make(chan lruEvent, 256),
+		size:     make(map[string]int64),
+		atime:    make(map[string]time.Time),
+	}
+}
+
+// seed populates the initial index from whatever the backend already
+// holds, using each entry's mod time as its starting recency.
+func (m *lruManager) seed() error {
+	names, err := cache.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		info, err := cache.Stat(name)
+		if err != nil {
+			continue
+		}
+		m.size[name] = info.Size()
+		m.atime[name] = info.ModTime()
+		m.total += info.Size()
+	}
+	log.Printf("Cache LRU: seeded %d entries, %d bytes (limit %d)", len(names), m.total, m.maxBytes)
+	return nil
+}
+
+// touch records a read hit on name, bumping it to most-recently-used
+// without changing its tracked size.
+func (m *lruManager) touch(name string) {
+	if m == nil {
+		return
+	}
+	m.events <- lruEvent{name: name, size: -1}
+}
+
+// inserted records a new or overwritten entry, which may push the cache
+// over maxBytes and trigger eviction.
+func (m *lruManager) inserted(name string, size int64) {
+	if m == nil {
+		return
+	}
+	m.events <- lruEvent{name: name, size: size}
+}
+
+// run is the single goroutine that owns m's maps, so recency updates and
+// evictions are always serialized against each other.
+func (m *lruManager) run() {
+	for ev := range m.events {
+		if ev.size >= 0 {
+			m.total += ev.size - m.size[ev.name]
+			m.size[ev.name] = ev.size
+		}
+		m.atime[ev.name] = time.Now()
+		m.evict()
+	}
+}
+
+// evict removes least-recently-used, non-pinned entries until the tracked
+// total fits under maxBytes.
+func (m *lruManager) evict() {
+	for m.total > m.maxBytes {
+		oldest, found := "", time.Time{}
+		for name, at := range m.atime {
+			if isDownloadActive(name) {
+				continue
+			}
+			if oldest == "" || at.Before(found) {
+				oldest, found = name, at
+			}
+		}
+		if oldest == "" {
+			// Everything left is pinned by an in-flight download; nothing
+			// more can be evicted right now.
+			return
+		}
+		if err := cache.Delete(oldest); err != nil {
+			log.Printf("Cache LRU: failed to evict %s: %v", oldest, err)
+			// Drop it from the index anyway so a permanently-unremovable
+			// entry doesn't spin this loop forever.
+		}
+		m.total -= m.size[oldest]
+		delete(m.size, oldest)
+		delete(m.atime, oldest)
+		log.Printf("Cache LRU: evicted %s, total now %d bytes", oldest, m.total)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func writeCacheEntry(t *testing.T, name, data string) {
+	t.Helper()
+	w, err := cache.Writer(name)
+	if err != nil {
+		t.Fatalf("Writer(%q): %v", name, err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("Write(%q): %v", name, err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit(%q): %v", name, err)
+	}
+}
+
+// TestEvictSkipsActiveDownload guards against the activeDownloads map
+// pinning a name forever once it's ever been fetched: evict must only
+// skip a name while its download is actually in flight.
+func TestEvictSkipsActiveDownload(t *testing.T) {
+	cache = newMemoryBackend()
+	writeCacheEntry(t, "old", "0123456789")
+
+	m := newLRUManager(5)
+	m.size["old"] = 10
+	m.atime["old"] = time.Now()
+	m.total = 10
+
+	release := acquireDownloadLock("old")
+	m.evict()
+	if _, err := cache.Stat("old"); err != nil {
+		t.Fatalf("entry evicted while its download was still in flight: %v", err)
+	}
+
+	release()
+	m.evict()
+	if _, err := cache.Stat("old"); err == nil {
+		t.Fatalf("entry should have been evicted once its download lock was released")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// partMeta sits alongside a <name>.part entry and records enough to safely
+// resume an interrupted CDN fetch: total size, ETag (to detect a changed
+// upstream object), and the last offset written successfully.
+type partMeta struct {
+	TotalSize int64  `json:"total_size"`
+	ETag      string `json:"etag"`
+	Offset    int64  `json:"offset"`
+}
+
+func partName(name string) string { return name + ".part" }
+func metaName(name string) string { return name + ".part.meta" }
+
+func readPartMeta(name string) (partMeta, bool) {
+	var m partMeta
+	f, err := cache.Open(metaName(name))
+	if err != nil {
+		return m, false
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return m, false
+	}
+	return m, true
+}
+
+func writePartMeta(name string, m partMeta) error {
+	w, err := cache.Writer(metaName(name))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		w.Discard()
+		return err
+	}
+	return w.Commit()
+}
+
+func discardPart(name string) {
+	cache.Delete(partName(name))
+	cache.Delete(metaName(name))
+}
+
+// downloadEntry is activeDownloads' per-name bookkeeping: the mutex that
+// coalesces concurrent fetches of that name, plus a refcount so the entry
+// is removed once nobody holds it rather than pinning the name forever.
+type downloadEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// acquireDownloadLock locks (creating if needed) the mutex for name and
+// returns a func that releases it. activeDownloadsMu only ever guards the
+// refcount and map membership, never the download itself, so unrelated
+// names never block each other.
+func acquireDownloadLock(name string) (release func()) {
+	activeDownloadsMu.Lock()
+	entry, ok := activeDownloads[name]
+	if !ok {
+		entry = &downloadEntry{}
+		activeDownloads[name] = entry
+	}
+	entry.refs++
+	activeDownloadsMu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+		activeDownloadsMu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(activeDownloads, name)
+		}
+		activeDownloadsMu.Unlock()
+	}
+}
+
+// isDownloadActive reports whether name currently has a fetch in flight.
+func isDownloadActive(name string) bool {
+	activeDownloadsMu.Lock()
+	defer activeDownloadsMu.Unlock()
+	_, ok := activeDownloads[name]
+	return ok
+}
+
+// fetchToCache fetches url, resuming from any existing <name>.part left by a
+// previous attempt, and commits it to name once complete. Callers must hold
+// acquireDownloadLock(name). It never applies Content-Encoding decompression,
+// so a decompressed copy (e.g. brotli served to a client that won't take br)
+// should not be cached via this path.
+func fetchToCache(ctx context.Context, url, name string) error {
+	meta, haveMeta := readPartMeta(name)
+
+	partW, offset, err := cache.PartWriter(partName(name))
+	if err != nil {
+		return err
+	}
+	if haveMeta && offset != meta.Offset {
+		// Part file and meta disagree, most likely a crash mid-write.
+		// Safer to restart this entry from scratch than to trust either.
+		partW.Close()
+		discardPart(name)
+		haveMeta = false
+		if partW, offset, err = cache.PartWriter(partName(name)); err != nil {
+			return err
+		}
+	}
+	defer func() { partW.Close() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if haveMeta && offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if meta.ETag != "" {
+			req.Header.Set("If-Range", meta.ETag)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Either a fresh download, or the CDN ignored our If-Range because
+		// the object changed -- either way the part file on disk (if any)
+		// no longer matches what we're about to receive.
+		if offset > 0 {
+			partW.Close()
+			discardPart(name)
+			offset = 0
+			if partW, _, err = cache.PartWriter(partName(name)); err != nil {
+				return err
+			}
+		}
+	case http.StatusPartialContent:
+		// Resuming; keep offset as-is.
+	default:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	total := offset + resp.ContentLength
+	if resp.ContentLength < 0 {
+		total = -1
+	}
+	downloadTracker.Progress(name, offset, total)
+
+	n, copyErr := io.Copy(partW, &progressReader{r: resp.Body, name: name, base: offset, total: total})
+	offset += n
+	if metaErr := writePartMeta(name, partMeta{TotalSize: total, ETag: resp.Header.Get("ETag"), Offset: offset}); metaErr != nil {
+		log.Printf("Warning: failed to persist resume metadata for %s: %v", name, metaErr)
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if total >= 0 && offset != total {
+		return fmt.Errorf("short read: got %d of %d bytes", offset, total)
+	}
+
+	return finalizeCacheEntry(name)
+}
+
+// progressReader wraps an in-flight download body, reporting cumulative
+// bytes read (base plus whatever was already on disk from a prior attempt)
+// to downloadTracker as the copy proceeds.
+type progressReader struct {
+	r           io.Reader
+	name        string
+	base, total int64
+	read        int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		downloadTracker.Progress(pr.name, pr.base+pr.read, pr.total)
+	}
+	return n, err
+}
+
+// finalizeCacheEntry promotes a fully-downloaded <name>.part to name and
+// removes the resume sidecar files.
+func finalizeCacheEntry(name string) error {
+	f, err := cache.Open(partName(name))
+	if err != nil {
+		return err
+	}
+	w, err := cache.Writer(name)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size, copyErr := verifyingCopy(w, f, name)
+	f.Close()
+	if copyErr != nil {
+		w.Discard()
+		var integrityErr *integrityError
+		if errors.As(copyErr, &integrityErr) {
+			// The downloaded bytes themselves are corrupt, not just this
+			// copy -- don't leave a bad .part around for a future resume
+			// to build on.
+			discardPart(name)
+		}
+		return copyErr
+	}
+	if err := w.Commit(); err != nil {
+		return err
+	}
+	discardPart(name)
+	lru.inserted(name, size)
+	return nil
+}
+
+// parseSingleRange understands the "bytes=N-" and "bytes=N-M" forms of the
+// Range header. end is -1 when the range has no explicit end. ok is false
+// for anything else (missing header, multiple ranges, suffix ranges), in
+// which case callers should not attempt the cache-assisted range path.
+func parseSingleRange(header string) (start, end int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, 0, false
+	}
+	spec := header[len(prefix):]
+	if strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if parts[1] == "" {
+		return start, -1, true
+	}
+	end, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// serveRangeFromPart serves [start,end] (inclusive) of a partial cache entry
+// directly, when it is already fully covered by what's been downloaded so
+// far. total is the full object size from the resume metadata, used for the
+// Content-Range header.
+func serveRangeFromPart(w http.ResponseWriter, name string, total, start, end int64) bool {
+	f, err := cache.Open(partName(name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		return false
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return false
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err := io.CopyN(w, f, end-start+1); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error serving cached range for %s: %v", name, err)
+	}
+	return true
+}
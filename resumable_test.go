@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// spyPartWriter counts Close calls on a single PartWriter instance, so a
+// test can tell exactly which writer handed out by a backend got closed.
+type spyPartWriter struct {
+	io.WriteCloser
+	closes int
+}
+
+func (w *spyPartWriter) Close() error {
+	w.closes++
+	return w.WriteCloser.Close()
+}
+
+// spyingBackend wraps a cacheBackend, recording every PartWriter it hands
+// out so a test can inspect their Close counts after the fact.
+type spyingBackend struct {
+	cacheBackend
+	writers []*spyPartWriter
+}
+
+func (b *spyingBackend) PartWriter(name string) (io.WriteCloser, int64, error) {
+	w, offset, err := b.cacheBackend.PartWriter(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	sw := &spyPartWriter{WriteCloser: w}
+	b.writers = append(b.writers, sw)
+	return sw, offset, nil
+}
+
+// setupStaleResumeFixture seeds the memory cache with a partial download
+// plus resume metadata, then starts a CDN stand-in that ignores If-Range
+// and responds 200 with body instead of a 206 -- the object-changed-mid-
+// resume case both fetchToCache and serveWindowWithResume must handle by
+// discarding the stale part and opening a fresh PartWriter.
+func setupStaleResumeFixture(t *testing.T, name string) (backend *spyingBackend, body []byte, srv *httptest.Server) {
+	t.Helper()
+	backend = &spyingBackend{cacheBackend: newMemoryBackend()}
+	cache = backend
+
+	pw, _, err := cache.PartWriter(partName(name))
+	if err != nil {
+		t.Fatalf("PartWriter: %v", err)
+	}
+	if _, err := pw.Write([]byte("stale-partial")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := writePartMeta(name, partMeta{TotalSize: 100, ETag: "etag-old", Offset: int64(len("stale-partial"))}); err != nil {
+		t.Fatalf("writePartMeta: %v", err)
+	}
+
+	body = []byte("brand-new-content")
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", "etag-new")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	return backend, body, srv
+}
+
+// TestFetchToCacheClosesReassignedPartWriter guards against a defer that
+// captures the pre-reset part writer instead of the one fetchToCache
+// actually reassigns partW to: when the CDN responds 200 instead of 206
+// because the object changed mid-resume, the stale writer is closed
+// explicitly and a fresh one takes its place. Every writer opened during
+// the call, old and new, must be closed exactly once.
+func TestFetchToCacheClosesReassignedPartWriter(t *testing.T) {
+	name := "asset.bin"
+	backend, body, srv := setupStaleResumeFixture(t, name)
+	defer srv.Close()
+
+	if err := fetchToCache(context.Background(), srv.URL, name); err != nil {
+		t.Fatalf("fetchToCache: %v", err)
+	}
+
+	// One writer from the setup above, plus two inside fetchToCache itself
+	// (the resumed attempt, then the reset one after the ETag change).
+	if len(backend.writers) != 3 {
+		t.Fatalf("want 3 PartWriters opened total, got %d", len(backend.writers))
+	}
+	for i, w := range backend.writers {
+		if w.closes != 1 {
+			t.Errorf("writer %d: want exactly 1 Close call, got %d", i, w.closes)
+		}
+	}
+
+	f, err := cache.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", name, err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading committed entry: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("committed entry = %q, want %q", got, body)
+	}
+}
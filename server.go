@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/subtle"
 	"embed"
@@ -15,6 +14,7 @@ import (
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -35,22 +35,38 @@ const (
 )
 
 var (
-	port          int
-	login         string
-	password      string
-	cdn           string
-	downloadDir   string
-	download      bool
-	downloadCache bool
-	workers       int
+	port             int
+	login            string
+	password         string
+	cdn              string
+	downloadDir      string
+	cacheBackendFlag string
+	download         bool
+	downloadCache    bool
+	workers          int
+	cacheMaxBytes    int64
+	verifyCacheFlag  bool
 
 	// Shared HTTP client with timeout
 	httpClient = &http.Client{
 		Timeout: httpTimeout,
 	}
 
-	// Track files being downloaded to prevent race conditions
-	activeDownloads sync.Map
+	// Storage for cached CDN assets. Selected via -cache-backend/CACHE_BACKEND
+	// in main() once flags are parsed; see cache.go.
+	cache cacheBackend
+
+	// activeDownloads holds one entry per cache entry name currently being
+	// fetched, so concurrent requests coalesce onto one in-flight fetch and
+	// lru.go can pin a name while its download is in flight. See
+	// acquireDownloadLock.
+	activeDownloadsMu sync.Mutex
+	activeDownloads   = map[string]*downloadEntry{}
+
+	// lru tracks recency and evicts entries once the cache exceeds
+	// -cache-max-bytes/CACHE_MAX_BYTES. Left nil (a no-op) when unset; see
+	// lru.go.
+	lru *lruManager
 )
 
 func init() {
@@ -58,10 +74,13 @@ func init() {
 	flag.StringVar(&login, "login", "", "HTTP Basic Auth username")
 	flag.StringVar(&password, "password", "", "HTTP Basic Auth password")
 	flag.StringVar(&cdn, "cdn", "https://cdn.dos.zone/vcsky/", "CDN base URL")
-	flag.StringVar(&downloadDir, "dir", "", "Asset cache directory (defaults to OS temp folder)")
+	flag.StringVar(&downloadDir, "dir", "", "Asset cache directory (defaults to OS temp folder, local backend only)")
+	flag.StringVar(&cacheBackendFlag, "cache-backend", "local", "Cache storage backend: local, memory, or s3")
 	flag.BoolVar(&download, "download", false, "Download all assets and exit")
 	flag.BoolVar(&downloadCache, "download-cache", false, "Download all assets to cache in the background")
 	flag.IntVar(&workers, "workers", 8, "Number of parallel download workers")
+	flag.Int64Var(&cacheMaxBytes, "cache-max-bytes", 0, "Evict least-recently-used cache entries once total size exceeds this many bytes (0 disables eviction)")
+	flag.BoolVar(&verifyCacheFlag, "verify", false, "Re-hash every cached entry against manifest.json, report corrupt ones, and exit")
 }
 
 func loadEnvConfig() {
@@ -83,6 +102,18 @@ func loadEnvConfig() {
 	if v := os.Getenv("DOWNLOAD_DIR"); v != "" {
 		downloadDir = v
 	}
+	if v := os.Getenv("CACHE_BACKEND"); v != "" {
+		cacheBackendFlag = v
+	}
+	if v := os.Getenv("S3_BUCKET"); v != "" {
+		s3Bucket = v
+	}
+	if v := os.Getenv("S3_PREFIX"); v != "" {
+		s3Prefix = v
+	}
+	if v := os.Getenv("S3_ENDPOINT"); v != "" {
+		s3Endpoint = v
+	}
 	if v := os.Getenv("DOWNLOAD_CACHE"); v == "1" || v == "true" {
 		downloadCache = true
 	}
@@ -91,20 +122,42 @@ func loadEnvConfig() {
 			workers = n
 		}
 	}
+	if v := os.Getenv("CACHE_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			cacheMaxBytes = n
+		}
+	}
 }
 
 func main() {
 	flag.Parse()
 	loadEnvConfig()
 
-	// Default to OS temp folder if not set
+	// Default to OS temp folder if not set (only used by the local backend)
 	if downloadDir == "" {
 		downloadDir = filepath.Join(os.TempDir(), "reVCDOS")
 	}
 
-	// Validate cache directory is usable
-	if err := os.MkdirAll(downloadDir, 0755); err != nil {
-		log.Fatalf("Cannot create cache directory %s: %v", downloadDir, err)
+	backend, err := newCacheBackend(cacheBackendFlag)
+	if err != nil {
+		log.Fatalf("Cannot initialize cache backend: %v", err)
+	}
+	cache = backend
+	loadManifest()
+
+	if verifyCacheFlag {
+		if err := runVerify(); err != nil {
+			log.Fatalf("Cache verification failed: %v", err)
+		}
+		return
+	}
+
+	if cacheMaxBytes > 0 {
+		lru = newLRUManager(cacheMaxBytes)
+		if err := lru.seed(); err != nil {
+			log.Fatalf("Cannot seed cache LRU index: %v", err)
+		}
+		go lru.run()
 	}
 
 	// Download and exit
@@ -138,6 +191,18 @@ func main() {
 	// Proxy routes
 	mux.HandleFunc("/vcsky/", handleVcsky)
 
+	// Cache export routes: GET bundles the whole cache, POST with repeated
+	// "path" form values bundles a chosen subset.
+	mux.HandleFunc("/vcsky.tar.gz", handleArchiveTarGz)
+	mux.HandleFunc("/vcsky.zip", handleArchiveZip)
+
+	// Background download progress API
+	mux.HandleFunc("/api/downloads", handleDownloadsSnapshot)
+	mux.HandleFunc("/api/downloads/stream", handleDownloadsStream)
+
+	// Cache integrity API
+	mux.HandleFunc("/api/integrity", handleIntegrity)
+
 	// Index route
 	mux.HandleFunc("/", handleRoot)
 
@@ -152,7 +217,7 @@ func main() {
 
 	fmt.Printf("Starting server on http://localhost:%d\n", port)
 	fmt.Printf("cdn: %s\n", cdn)
-	fmt.Printf("cache: %s\n", downloadDir)
+	fmt.Printf("cache: backend=%s\n", cacheBackendFlag)
 
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), handler))
 }
@@ -181,6 +246,17 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// Flush forwards to the underlying ResponseWriter when it supports
+// http.Flusher. Without this, embedding http.ResponseWriter only promotes
+// the three methods that interface declares -- Flush isn't one of them --
+// so a streaming handler downstream (e.g. the SSE feed in downloads.go)
+// would silently buffer instead of pushing bytes as they're written.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
@@ -309,10 +385,8 @@ func handleVcsky(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	localPath := filepath.Join(downloadDir, path)
-
-	// Try local file first
-	if serveLocalFile(w, r, localPath) {
+	// Try cache first
+	if serveLocalFile(w, r, path) {
 		return
 	}
 
@@ -323,54 +397,54 @@ func handleVcsky(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("fetch %s%s", cdn, path)
-	proxyAndCache(w, r, url, localPath)
+	proxyAndCache(w, r, url, path)
 }
 
 // File serving and proxying
 
-func serveLocalFile(w http.ResponseWriter, r *http.Request, localPath string) bool {
+func serveLocalFile(w http.ResponseWriter, r *http.Request, name string) bool {
 	acceptEncoding := strings.ToLower(r.Header.Get("Accept-Encoding"))
 	clientAcceptsBr := strings.Contains(acceptEncoding, "br")
 	clientAcceptsGzip := strings.Contains(acceptEncoding, "gzip")
 
-	// Determine base path and check for compressed versions
-	basePath := strings.TrimSuffix(strings.TrimSuffix(localPath, ".br"), ".gz")
-	isBrFile := strings.HasSuffix(localPath, ".br")
-	isGzFile := strings.HasSuffix(localPath, ".gz")
+	// Determine base name and check for compressed versions
+	baseName := strings.TrimSuffix(strings.TrimSuffix(name, ".br"), ".gz")
+	isBrFile := strings.HasSuffix(name, ".br")
+	isGzFile := strings.HasSuffix(name, ".gz")
 
 	// Try to serve pre-compressed versions if they exist
-	var servePath string
+	var serveName string
 	var encoding string
 
 	if clientAcceptsBr {
-		brPath := basePath + ".br"
-		if info, err := os.Stat(brPath); err == nil && !info.IsDir() {
-			servePath = brPath
+		brName := baseName + ".br"
+		if info, err := cache.Stat(brName); err == nil && !info.IsDir() {
+			serveName = brName
 			encoding = "br"
 		}
 	}
-	if servePath == "" && clientAcceptsGzip {
-		gzPath := basePath + ".gz"
-		if info, err := os.Stat(gzPath); err == nil && !info.IsDir() {
-			servePath = gzPath
+	if serveName == "" && clientAcceptsGzip {
+		gzName := baseName + ".gz"
+		if info, err := cache.Stat(gzName); err == nil && !info.IsDir() {
+			serveName = gzName
 			encoding = "gzip"
 		}
 	}
-	if servePath == "" {
-		// Try the original requested path
-		info, err := os.Stat(localPath)
+	if serveName == "" {
+		// Try the original requested name
+		info, err := cache.Stat(name)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return false
 			}
-			log.Printf("Error accessing file %s: %v", localPath, err)
+			log.Printf("Error accessing cache entry %s: %v", name, err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return true
 		}
 		if info.IsDir() {
 			return false
 		}
-		servePath = localPath
+		serveName = name
 		if isBrFile {
 			encoding = "br"
 		} else if isGzFile {
@@ -379,28 +453,29 @@ func serveLocalFile(w http.ResponseWriter, r *http.Request, localPath string) bo
 	}
 
 	// Set content type based on the uncompressed filename
-	contentType := mime.TypeByExtension(filepath.Ext(basePath))
+	contentType := mime.TypeByExtension(filepath.Ext(baseName))
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
-	if strings.HasSuffix(basePath, ".wasm") {
+	if strings.HasSuffix(baseName, ".wasm") {
 		contentType = "application/wasm"
 	}
 	w.Header().Set("Content-Type", contentType)
 
+	file, err := cache.Open(serveName)
+	if err != nil {
+		log.Printf("Error opening cache entry %s: %v", serveName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	defer file.Close()
+	lru.touch(serveName)
+
 	// Handle brotli decompression if client doesn't support it
 	if encoding == "br" && !clientAcceptsBr {
-		file, err := os.Open(servePath)
-		if err != nil {
-			log.Printf("Error opening file %s: %v", servePath, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return true // Handled (with error)
-		}
-		defer file.Close()
-
 		reader := brotli.NewReader(file)
 		if _, err := io.Copy(w, reader); err != nil {
-			log.Printf("Error decompressing file %s: %v", servePath, err)
+			log.Printf("Error decompressing cache entry %s: %v", serveName, err)
 		}
 		return true
 	}
@@ -410,32 +485,83 @@ func serveLocalFile(w http.ResponseWriter, r *http.Request, localPath string) bo
 		w.Header().Set("Content-Encoding", encoding)
 	}
 
-	http.ServeFile(w, r, servePath)
+	// http.ServeContent needs random access for Range requests; only the
+	// backends that can offer it (e.g. local disk) pass the type assertion,
+	// everything else falls back to a plain copy.
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		modTime := time.Time{}
+		if info, err := file.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+		http.ServeContent(w, r, filepath.Base(baseName), modTime, seeker)
+		return true
+	}
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Error serving cache entry %s: %v", serveName, err)
+	}
 	return true
 }
 
-func proxyAndCache(w http.ResponseWriter, r *http.Request, url string, localPath string) {
-	// Try local first
-	if serveLocalFile(w, r, localPath) {
+func proxyAndCache(w http.ResponseWriter, r *http.Request, url string, name string) {
+	// Try cache first
+	if serveLocalFile(w, r, name) {
 		return
 	}
 
-	// Check if file is being downloaded by background goroutine
-	_, alreadyDownloading := activeDownloads.Load(localPath)
+	// Coalesce concurrent requests (plain or ranged) for the same entry
+	// onto a single in-flight fetch instead of racing each other's writes
+	// to the same .part file.
+	release := acquireDownloadLock(name)
+	defer release()
+
+	if serveLocalFile(w, r, name) {
+		return
+	}
 
-	// Use context for cancellation
 	ctx, cancel := context.WithTimeout(r.Context(), httpTimeout)
 	defer cancel()
 
+	clientAcceptsBr := strings.Contains(strings.ToLower(r.Header.Get("Accept-Encoding")), "br")
+	needDecompress := strings.HasSuffix(url, ".br") && !clientAcceptsBr
+	if needDecompress {
+		// A brotli stream can't be resumed from an arbitrary byte offset,
+		// so these are proxied straight through without the resumable
+		// cache path below.
+		proxyPassthrough(ctx, w, r, url, true)
+		return
+	}
+
+	winStart, winEnd, hasRange := parseSingleRange(r.Header.Get("Range"))
+	if !hasRange {
+		winStart, winEnd = 0, -1
+	} else if meta, ok := readPartMeta(name); ok && meta.TotalSize > 0 {
+		end := winEnd
+		if end < 0 || end >= meta.TotalSize {
+			end = meta.TotalSize - 1
+		}
+		if meta.Offset > end && serveRangeFromPart(w, name, meta.TotalSize, winStart, end) {
+			return
+		}
+	}
+
+	serveWindowWithResume(ctx, w, r, url, name, winStart, winEnd)
+}
+
+// proxyPassthrough streams url straight to w without touching the cache,
+// optionally decompressing a brotli body for a client that doesn't accept
+// br. Used for brotli assets (not resumable) and as a fallback when the
+// cache backend itself is unavailable.
+func proxyPassthrough(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, decompress bool) {
 	req, err := http.NewRequestWithContext(ctx, r.Method, url, nil)
 	if err != nil {
 		log.Printf("Error creating proxy request to %s: %v", url, err)
 		http.Error(w, "Proxy error: failed to create request", http.StatusBadGateway)
 		return
 	}
-
 	for k, v := range r.Header {
-		if strings.ToLower(k) != "host" && strings.ToLower(k) != "accept-encoding" {
+		lower := strings.ToLower(k)
+		if lower != "host" && lower != "accept-encoding" {
 			req.Header[k] = v
 		}
 	}
@@ -448,202 +574,245 @@ func proxyAndCache(w http.ResponseWriter, r *http.Request, url string, localPath
 	}
 	defer resp.Body.Close()
 
-	// Check content length for size limit
 	if resp.ContentLength > maxProxySize {
 		log.Printf("Proxy response from %s too large: %d bytes", url, resp.ContentLength)
 		http.Error(w, "Response too large", http.StatusBadGateway)
 		return
 	}
-
-	// Limit actual bytes read to prevent chunked encoding bypass
 	limitedBody := io.LimitReader(resp.Body, maxProxySize)
 
-	// Copy response headers
 	for k, v := range resp.Header {
 		kLower := strings.ToLower(k)
 		if kLower != "transfer-encoding" && kLower != "connection" && kLower != "content-security-policy" {
 			w.Header()[k] = v
 		}
 	}
-
-	isBrFile := strings.HasSuffix(url, ".br")
-	clientAcceptsBr := strings.Contains(strings.ToLower(r.Header.Get("Accept-Encoding")), "br")
-	needDecompress := isBrFile && !clientAcceptsBr
-
-	if needDecompress {
+	if decompress {
 		w.Header().Del("Content-Encoding")
 		w.Header().Del("Content-Length")
 	}
 
-	// Don't cache non-200 responses
-	if resp.StatusCode != http.StatusOK {
-		w.WriteHeader(resp.StatusCode)
-		if needDecompress {
-			reader := brotli.NewReader(limitedBody)
-			if _, err := io.Copy(w, reader); err != nil {
-				log.Printf("Error decompressing proxy response from %s: %v", url, err)
-			}
-		} else {
-			if _, err := io.Copy(w, limitedBody); err != nil {
-				log.Printf("Error copying proxy response from %s: %v", url, err)
-			}
+	w.WriteHeader(resp.StatusCode)
+	if decompress {
+		if _, err := io.Copy(w, brotli.NewReader(limitedBody)); err != nil {
+			log.Printf("Error decompressing proxy response from %s: %v", url, err)
 		}
 		return
 	}
+	if _, err := io.Copy(w, limitedBody); err != nil {
+		log.Printf("Error copying proxy response from %s: %v", url, err)
+	}
+}
 
-	// Skip caching if background download is in progress
-	if alreadyDownloading {
-		w.WriteHeader(resp.StatusCode)
-		if needDecompress {
-			reader := brotli.NewReader(limitedBody)
-			if _, err := io.Copy(w, reader); err != nil {
-				log.Printf("Error decompressing proxy response: %v", err)
-			}
-		} else {
-			if _, err := io.Copy(w, limitedBody); err != nil {
-				log.Printf("Error copying proxy response: %v", err)
-			}
-		}
+// serveWindowWithResume fetches url, resuming from any bytes already sitting
+// in <name>.part, and serves [winStart,winEnd] (winEnd -1 meaning EOF) of
+// the result to w -- from the cached prefix, the live network tail, or
+// both. The live tail is teed to the .part file regardless of how much of
+// it the client's window actually needs, so the cache keeps advancing
+// toward a complete entry across repeated partial requests.
+func serveWindowWithResume(ctx context.Context, w http.ResponseWriter, r *http.Request, url, name string, winStart, winEnd int64) {
+	meta, haveMeta := readPartMeta(name)
+	partW, offset, err := cache.PartWriter(partName(name))
+	if err != nil {
+		log.Printf("Warning: cannot open cache writer for %s: %v (proxying without cache)", name, err)
+		proxyPassthrough(ctx, w, r, url, false)
 		return
 	}
-
-	// Create cache directory
-	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-		log.Printf("Warning: failed to create cache directory for %s: %v", localPath, err)
-		// Continue without caching
-		w.WriteHeader(resp.StatusCode)
-		if needDecompress {
-			reader := brotli.NewReader(limitedBody)
-			if _, err := io.Copy(w, reader); err != nil {
-				log.Printf("Error decompressing proxy response: %v", err)
-			}
-		} else {
-			if _, err := io.Copy(w, limitedBody); err != nil {
-				log.Printf("Error copying proxy response: %v", err)
-			}
+	reset := func() error {
+		partW.Close()
+		discardPart(name)
+		haveMeta = false
+		var rerr error
+		partW, offset, rerr = cache.PartWriter(partName(name))
+		return rerr
+	}
+	if haveMeta && offset != meta.Offset {
+		// Part file and meta disagree, most likely a crash mid-write;
+		// safer to restart this entry than to trust either one.
+		if err := reset(); err != nil {
+			log.Printf("Warning: cannot reset cache writer for %s: %v (proxying without cache)", name, err)
+			proxyPassthrough(ctx, w, r, url, false)
+			return
 		}
-		return
 	}
+	defer func() { partW.Close() }()
 
-	// Create temp file for caching
-	tempFile, err := os.CreateTemp(filepath.Dir(localPath), ".tmp-*")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("Warning: cannot create temp file for caching %s: %v (proxying without cache)", localPath, err)
-		w.WriteHeader(resp.StatusCode)
-		if needDecompress {
-			reader := brotli.NewReader(limitedBody)
-			if _, err := io.Copy(w, reader); err != nil {
-				log.Printf("Error decompressing proxy response: %v", err)
-			}
-		} else {
-			if _, err := io.Copy(w, limitedBody); err != nil {
-				log.Printf("Error copying proxy response: %v", err)
-			}
-		}
+		log.Printf("Error creating proxy request to %s: %v", url, err)
+		http.Error(w, "Proxy error: failed to create request", http.StatusBadGateway)
 		return
 	}
-	tempFileName := tempFile.Name()
-
-	w.WriteHeader(resp.StatusCode)
-
-	// Use TeeReader to write to cache while reading
-	// This correctly handles the stream splitting
-	teeReader := io.TeeReader(limitedBody, tempFile)
-
-	var copyErr error
-	if needDecompress {
-		// Decompress the tee'd stream for the client
-		brReader := brotli.NewReader(teeReader)
-		_, copyErr = io.Copy(w, brReader)
-	} else {
-		// Send raw data to client (tee already writes to cache)
-		_, copyErr = io.Copy(w, teeReader)
+	for k, v := range r.Header {
+		lower := strings.ToLower(k)
+		if lower != "host" && lower != "accept-encoding" && lower != "range" {
+			req.Header[k] = v
+		}
+	}
+	if haveMeta && offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if meta.ETag != "" {
+			req.Header.Set("If-Range", meta.ETag)
+		}
 	}
 
-	tempFile.Close()
-
-	if copyErr != nil {
-		log.Printf("Error during proxy/cache of %s: %v", url, copyErr)
-		os.Remove(tempFileName)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Proxy request to %s failed: %v", url, err)
+		http.Error(w, fmt.Sprintf("Proxy error: %v", err), http.StatusBadGateway)
 		return
 	}
+	defer resp.Body.Close()
 
-	// Atomically move temp file to final location
-	if err := os.Rename(tempFileName, localPath); err != nil {
-		log.Printf("Error caching file %s: %v", localPath, err)
-		os.Remove(tempFileName)
+	if resp.ContentLength > maxProxySize {
+		log.Printf("Proxy response from %s too large: %d bytes", url, resp.ContentLength)
+		http.Error(w, "Response too large", http.StatusBadGateway)
+		return
 	}
-}
+	limitedBody := io.LimitReader(resp.Body, maxProxySize)
 
-// Download functions
+	// Don't cache non-200/206 responses; just relay them as-is.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		for k, v := range resp.Header {
+			kLower := strings.ToLower(k)
+			if kLower != "transfer-encoding" && kLower != "connection" && kLower != "content-security-policy" {
+				w.Header()[k] = v
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, limitedBody)
+		return
+	}
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		// The CDN ignored our conditional Range, most likely because the
+		// object changed since we started caching it.
+		if err := reset(); err != nil {
+			log.Printf("Warning: failed to reset cache entry for %s: %v", name, err)
+		}
+	}
 
-func downloadFile(url, destPath string) error {
-	// Skip if file exists
-	if _, err := os.Stat(destPath); err == nil {
-		return nil
+	total := offset + resp.ContentLength
+	if resp.ContentLength < 0 {
+		total = -1
 	}
 
-	// Skip if already being downloaded
-	if _, loaded := activeDownloads.LoadOrStore(destPath, true); loaded {
-		return nil
+	end := winEnd
+	if end < 0 && total >= 0 {
+		end = total - 1
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if winStart == 0 && winEnd < 0 {
+		if total >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(total, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", winStart, end, totalOrStar(total)))
+		if end >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(end-winStart+1, 10))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	// Serve whatever part of the requested window is already cached.
+	pos := offset
+	if winStart < offset {
+		if f, ferr := cache.Open(partName(name)); ferr == nil {
+			if seeker, ok := f.(io.ReadSeeker); ok {
+				if _, serr := seeker.Seek(winStart, io.SeekStart); serr == nil {
+					prefixEnd := offset
+					if end >= 0 && end+1 < prefixEnd {
+						prefixEnd = end + 1
+					}
+					io.CopyN(w, seeker, prefixEnd-winStart)
+				}
+			}
+			f.Close()
+		}
+	} else {
+		pos = winStart
 	}
-	defer activeDownloads.Delete(destPath)
 
-	// Double-check file doesn't exist (another goroutine may have finished)
-	if _, err := os.Stat(destPath); err == nil {
-		return nil
+	// Tee the live network tail to the part file, forwarding to the client
+	// only the portion that still falls inside its requested window.
+	teeReader := io.TeeReader(limitedBody, partW)
+	var reader io.Reader = teeReader
+	if skip := pos - offset; skip > 0 {
+		io.CopyN(io.Discard, teeReader, skip)
+		pos += skip
 	}
 
-	// Create directory
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return err
+	var copyErr error
+	var sent int64
+	if end >= 0 && pos <= end {
+		sent, copyErr = io.CopyN(w, reader, end-pos+1)
+		if copyErr == io.EOF {
+			copyErr = nil
+		}
+	} else if end < 0 {
+		sent, copyErr = io.Copy(w, reader)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	// Drain whatever remains so the part file keeps the full response even
+	// when the client's window ended before the upstream body did.
+	drained, drainErr := io.Copy(partW, limitedBody)
+	if copyErr == nil {
+		copyErr = drainErr
 	}
-	req.Header.Set("Accept-Encoding", "gzip, br")
+	newOffset := pos + sent + drained
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return err
+	if metaErr := writePartMeta(name, partMeta{TotalSize: total, ETag: resp.Header.Get("ETag"), Offset: newOffset}); metaErr != nil {
+		log.Printf("Warning: failed to persist resume metadata for %s: %v", name, metaErr)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status %d", resp.StatusCode)
+	if copyErr != nil {
+		log.Printf("Error during proxy/cache of %s: %v", url, copyErr)
+		return
 	}
 
-	// Decompress if needed
-	var body io.Reader = resp.Body
-	switch resp.Header.Get("Content-Encoding") {
-	case "br":
-		body = brotli.NewReader(resp.Body)
-	case "gzip":
-		gr, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return err
+	if total >= 0 && newOffset == total {
+		if err := finalizeCacheEntry(name); err != nil {
+			log.Printf("Error finalizing cache entry %s: %v", name, err)
 		}
-		defer gr.Close()
-		body = gr
 	}
+}
 
-	// Write to temp file first
-	tmpPath := destPath + ".tmp"
-	out, err := os.Create(tmpPath)
-	if err != nil {
-		return err
+func totalOrStar(total int64) string {
+	if total < 0 {
+		return "*"
 	}
+	return strconv.FormatInt(total, 10)
+}
 
-	_, err = io.Copy(out, body)
-	out.Close()
-	if err != nil {
-		os.Remove(tmpPath)
-		return err
+// Download functions
+
+func downloadFile(url, name string) error {
+	// Skip if already cached
+	if _, err := cache.Stat(name); err == nil {
+		return nil
 	}
 
-	return os.Rename(tmpPath, destPath)
+	// Coalesce concurrent workers downloading the same name, and let a
+	// retry after a crash or network error resume from where it left off
+	// instead of starting a 400MB asset over from byte zero.
+	release := acquireDownloadLock(name)
+	defer release()
+
+	// Double-check it isn't cached yet (another goroutine may have finished)
+	if _, err := cache.Stat(name); err == nil {
+		return nil
+	}
+
+	downloadTracker.Start(name)
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+
+	err := fetchToCache(ctx, url, name)
+	downloadTracker.Complete(name, err)
+	return err
 }
 
 func doDownloadAssets() error {
@@ -666,9 +835,9 @@ func doDownloadAssets() error {
 	log.Printf("Loaded %d files from streaming_files.txt", len(files))
 
 	baseURL := strings.TrimSuffix(cdn, "/") + "/fetched/models/gta3.img"
-	outputDir := filepath.Join(downloadDir, "fetched/models/gta3.img")
+	nameDir := "fetched/models/gta3.img"
 
-	return downloadWithWorkers(files, baseURL, outputDir, "assets")
+	return downloadWithWorkers(files, baseURL, nameDir, "assets")
 }
 
 func doDownloadAudio() error {
@@ -677,10 +846,10 @@ func doDownloadAudio() error {
 	// Radio stations
 	radioFiles := []string{"kchat.adf", "vcpr.adf", "fever.adf", "vrock.adf", "wave.adf", "emotion.adf", "espant.adf"}
 	baseURL := strings.TrimSuffix(cdn, "/") + "/fetched/audio"
-	outputDir := filepath.Join(downloadDir, "fetched/audio")
+	nameDir := "fetched/audio"
 
 	log.Printf("Downloading %d radio stations...", len(radioFiles))
-	if err := downloadWithWorkers(radioFiles, baseURL, outputDir, "radio"); err != nil {
+	if err := downloadWithWorkers(radioFiles, baseURL, nameDir, "radio"); err != nil {
 		return err
 	}
 
@@ -691,16 +860,20 @@ func doDownloadAudio() error {
 	}
 
 	log.Printf("Downloading %d SFX files...", len(sfxFiles))
-	return downloadWithWorkers(sfxFiles, baseURL+"/sfx.raw", filepath.Join(outputDir, "sfx.raw"), "sfx")
+	return downloadWithWorkers(sfxFiles, baseURL+"/sfx.raw", nameDir+"/sfx.raw", "sfx")
 }
 
-func downloadWithWorkers(files []string, baseURL, outputDir, label string) error {
+func downloadWithWorkers(files []string, baseURL, nameDir, label string) error {
 	total := len(files)
 	var downloaded, skipped, failed atomic.Int64
 
 	// Create work channel
 	work := make(chan string, workers)
 
+	for _, file := range files {
+		downloadTracker.Queue(path.Join(nameDir, file))
+	}
+
 	// Start workers
 	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
@@ -708,16 +881,17 @@ func downloadWithWorkers(files []string, baseURL, outputDir, label string) error
 		go func() {
 			defer wg.Done()
 			for file := range work {
-				destPath := filepath.Join(outputDir, file)
+				name := path.Join(nameDir, file)
 
-				// Check if exists
-				if _, err := os.Stat(destPath); err == nil {
+				// Check if already cached
+				if _, err := cache.Stat(name); err == nil {
 					skipped.Add(1)
+					downloadTracker.Complete(name, nil)
 					continue
 				}
 
 				url := baseURL + "/" + file
-				if err := downloadFile(url, destPath); err != nil {
+				if err := downloadFile(url, name); err != nil {
 					failed.Add(1)
 					done := downloaded.Load() + skipped.Load() + failed.Load()
 					log.Printf("[%s] %d/%d FAIL: %s (%v)", label, done, total, file, err)
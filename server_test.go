@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServeWindowWithResumeClosesReassignedPartWriter is serveWindowWithResume's
+// counterpart to TestFetchToCacheClosesReassignedPartWriter: it has its own
+// reset closure and deferred Close over the same partW variable, so it can
+// regress independently of fetchToCache.
+func TestServeWindowWithResumeClosesReassignedPartWriter(t *testing.T) {
+	name := "asset.bin"
+	backend, body, srv := setupStaleResumeFixture(t, name)
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/vcsky/"+name, nil)
+	rec := httptest.NewRecorder()
+	serveWindowWithResume(context.Background(), rec, req, srv.URL, name, 0, -1)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec.Body.String() != string(body) {
+		t.Errorf("response body = %q, want %q", rec.Body.String(), body)
+	}
+
+	// One writer from the setup above, plus two inside serveWindowWithResume
+	// itself (the resumed attempt, then the reset one after the ETag change).
+	if len(backend.writers) != 3 {
+		t.Fatalf("want 3 PartWriters opened total, got %d", len(backend.writers))
+	}
+	for i, w := range backend.writers {
+		if w.closes != 1 {
+			t.Errorf("writer %d: want exactly 1 Close call, got %d", i, w.closes)
+		}
+	}
+
+	f, err := cache.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", name, err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading committed entry: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("committed entry = %q, want %q", got, body)
+	}
+}